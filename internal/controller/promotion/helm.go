@@ -2,10 +2,13 @@ package promotion
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
 
 	"gopkg.in/yaml.v3"
@@ -17,6 +20,11 @@ import (
 	libYAML "github.com/akuity/kargo/internal/yaml"
 )
 
+// digestRegex matches a well-formed, lowercase image digest of the form
+// <algorithm>:<hex>, using one of the algorithms registered by the OCI image
+// specification, with an encoded portion of exactly that algorithm's length.
+var digestRegex = regexp.MustCompile(`^(?:sha256:[a-f0-9]{64}|sha512:[a-f0-9]{128})$`)
+
 // newGenericGitMechanism returns a gitMechanism that only only selects and
 // performs updates that involve Helm.
 func newHelmMechanism(
@@ -53,7 +61,7 @@ type helmer struct {
 	buildValuesFilesChangesFn func(
 		[]kargoapi.Image,
 		[]kargoapi.HelmImageUpdate,
-	) (map[string]map[string]string, []string)
+	) (map[string]map[string]string, []string, error)
 	buildChartDependencyChangesFn func(
 		string,
 		[]kargoapi.Chart,
@@ -76,13 +84,31 @@ func (h *helmer) apply(
 	workingDir string,
 	_ git.RepoCredentials,
 ) ([]string, error) {
+	// Refuse to touch anything outside the working directory. Paths are checked
+	// again, and resolved, as each file is written below.
+	for _, imageUpdate := range update.Helm.Images {
+		if _, err := securePath(workingDir, imageUpdate.ValuesFilePath); err != nil {
+			return nil, fmt.Errorf("invalid values file path: %w", err)
+		}
+	}
+	for _, chartUpdate := range update.Helm.Charts {
+		if _, err := securePath(workingDir, chartUpdate.ChartPath); err != nil {
+			return nil, fmt.Errorf("invalid chart path: %w", err)
+		}
+	}
+
 	// Image updates
-	changesByFile, imageChangeSummary := h.buildValuesFilesChangesFn(newFreight.Images, update.Helm.Images)
+	changesByFile, imageChangeSummary, err :=
+		h.buildValuesFilesChangesFn(newFreight.Images, update.Helm.Images)
+	if err != nil {
+		return nil, fmt.Errorf("preparing changes to affected values files: %w", err)
+	}
 	for file, changes := range changesByFile {
-		if err := h.setStringsInYAMLFileFn(
-			filepath.Join(workingDir, file),
-			changes,
-		); err != nil {
+		absFile, err := securePath(workingDir, file)
+		if err != nil {
+			return nil, fmt.Errorf("invalid values file path: %w", err)
+		}
+		if err = h.setStringsInYAMLFileFn(absFile, changes); err != nil {
 			return nil, fmt.Errorf("updating values in file %q: %w", file, err)
 		}
 	}
@@ -98,8 +124,14 @@ func (h *helmer) apply(
 		return nil, fmt.Errorf("preparing changes to affected Chart.yaml files: %w", err)
 	}
 	for chart, changes := range changesByChart {
-		chartPath := filepath.Join(workingDir, chart)
-		chartYAMLPath := filepath.Join(chartPath, "Chart.yaml")
+		chartPath, err := securePath(workingDir, chart)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chart path: %w", err)
+		}
+		chartYAMLPath, err := securePath(workingDir, filepath.Join(chart, "Chart.yaml"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid chart path: %w", err)
+		}
 		if err = h.setStringsInYAMLFileFn(chartYAMLPath, changes); err != nil {
 			return nil, fmt.Errorf("setting dependency versions for chart %q: %w", chart, err)
 		}
@@ -114,22 +146,76 @@ func (h *helmer) apply(
 	return append(imageChangeSummary, subchartChangeSummary...), nil
 }
 
+// securePath joins the provided relative path to the provided root directory,
+// resolves any symlinks in the result, and returns it. An error is returned if
+// the path is absolute or if the resolved path would fall outside the root
+// directory, e.g. because it contains .. elements or because a symlink within
+// the root points elsewhere.
+func securePath(root, p string) (string, error) {
+	if filepath.IsAbs(p) {
+		return "", fmt.Errorf("path %q is absolute; it must be relative", p)
+	}
+	absRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", fmt.Errorf("error resolving working directory: %w", err)
+	}
+	resolved, err := evalSymlinks(filepath.Join(absRoot, p))
+	if err != nil {
+		return "", fmt.Errorf("error resolving path %q: %w", p, err)
+	}
+	rel, err := filepath.Rel(absRoot, resolved)
+	if err != nil {
+		return "", fmt.Errorf("error resolving path %q: %w", p, err)
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %q falls outside of the working directory", p)
+	}
+	return resolved, nil
+}
+
+// evalSymlinks is like filepath.EvalSymlinks, except that trailing elements of
+// the provided path that do not exist are tolerated and appended, unresolved,
+// to the resolved remainder. A symlink whose target does not exist is not
+// tolerated, since writing through it could create a file anywhere.
+func evalSymlinks(p string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(p)
+	if err == nil || !os.IsNotExist(err) {
+		return resolved, err
+	}
+	if _, lerr := os.Lstat(p); lerr == nil {
+		return "", fmt.Errorf("%q is a symlink to a path that does not exist", p)
+	}
+	parent := filepath.Dir(p)
+	if parent == p {
+		return "", err
+	}
+	if resolved, err = evalSymlinks(parent); err != nil {
+		return "", err
+	}
+	return filepath.Join(resolved, filepath.Base(p)), nil
+}
+
 // buildValuesFilesChanges takes a list of images and a list of instructions
 // about changes that should be made to various YAML files and distills them
 // into a map of maps that indexes new values for each YAML file by file name
-// and key.
+// and key. Digests are normalized to lowercase. An error is returned if any
+// update calls for an image's tag or digest, but the Freight has an empty tag
+// or an empty or malformed digest for that image. All such problems are
+// reported together, not just the first.
 func buildValuesFilesChanges(
 	images []kargoapi.Image,
 	imageUpdates []kargoapi.HelmImageUpdate,
-) (map[string]map[string]string, []string) {
+) (map[string]map[string]string, []string, error) {
 	tagsByImage := map[string]string{}
 	digestsByImage := make(map[string]string, len(images))
 	for _, image := range images {
 		tagsByImage[image.RepoURL] = image.Tag
-		digestsByImage[image.RepoURL] = image.Digest
+		// Some registries report digests in mixed case
+		digestsByImage[image.RepoURL] = strings.ToLower(image.Digest)
 	}
 	changesByFile := make(map[string]map[string]string, len(imageUpdates))
 	changeSummary := make([]string, 0, len(imageUpdates))
+	var errs []error
 	for _, imageUpdate := range imageUpdates {
 		switch imageUpdate.Value {
 		case kargoapi.ImageUpdateValueTypeImageAndTag,
@@ -146,6 +232,40 @@ func buildValuesFilesChanges(
 			// There's no change to make in this case.
 			continue
 		}
+		switch imageUpdate.Value {
+		case kargoapi.ImageUpdateValueTypeImageAndTag,
+			kargoapi.ImageUpdateValueTypeTag:
+			if tag == "" {
+				errs = append(errs, fmt.Errorf(
+					"cannot update key %q in %s: no tag is known for image %q",
+					imageUpdate.Key,
+					imageUpdate.ValuesFilePath,
+					imageUpdate.Image,
+				))
+				continue
+			}
+		case kargoapi.ImageUpdateValueTypeImageAndDigest,
+			kargoapi.ImageUpdateValueTypeDigest:
+			if digest == "" {
+				errs = append(errs, fmt.Errorf(
+					"cannot update key %q in %s: no digest is known for image %q",
+					imageUpdate.Key,
+					imageUpdate.ValuesFilePath,
+					imageUpdate.Image,
+				))
+				continue
+			}
+			if !digestRegex.MatchString(digest) {
+				errs = append(errs, fmt.Errorf(
+					"cannot update key %q in %s: digest %q for image %q is malformed",
+					imageUpdate.Key,
+					imageUpdate.ValuesFilePath,
+					digest,
+					imageUpdate.Image,
+				))
+				continue
+			}
+		}
 		if _, found := changesByFile[imageUpdate.ValuesFilePath]; !found {
 			changesByFile[imageUpdate.ValuesFilePath] = map[string]string{}
 		}
@@ -176,7 +296,10 @@ func buildValuesFilesChanges(
 			),
 		)
 	}
-	return changesByFile, changeSummary
+	if len(errs) > 0 {
+		return nil, nil, errors.Join(errs...)
+	}
+	return changesByFile, changeSummary, nil
 }
 
 // buildChartDependencyChanges takes a list of charts and a list of instructions
@@ -192,27 +315,37 @@ func buildChartDependencyChanges(
 	versionsByChart := make(map[string]string, len(charts))
 	for _, chart := range charts {
 		// path.Join accounts for the possibility that chart.Name is empty
-		key := path.Join(chart.RepoURL, chart.Name)
+		key := path.Join(helm.NormalizeChartRepositoryURL(chart.RepoURL), chart.Name)
 		versionsByChart[key] = chart.Version
 	}
 
-	// Build a de-duped set of paths to affected Charts files
-	chartPaths := make(map[string]struct{}, len(chartUpdates))
+	// Build a de-duped, sorted list of paths to affected Charts files so that
+	// the change summary comes out in the same order every time
+	chartPaths := make([]string, 0, len(chartUpdates))
 	for _, chartUpdate := range chartUpdates {
-		chartPaths[chartUpdate.ChartPath] = struct{}{}
+		if !slices.Contains(chartPaths, chartUpdate.ChartPath) {
+			chartPaths = append(chartPaths, chartUpdate.ChartPath)
+		}
 	}
+	slices.Sort(chartPaths)
 
 	// For each chart, build the appropriate changes
 	changesByFile := make(map[string]map[string]string)
 	changeSummary := make([]string, 0)
-	for chartPath := range chartPaths {
-		absChartYAMLPath := filepath.Join(repoDir, chartPath, "Chart.yaml")
+	for _, chartPath := range chartPaths {
+		absChartYAMLPath, err := securePath(repoDir, filepath.Join(chartPath, "Chart.yaml"))
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid chart path: %w", err)
+		}
 		chartDependencies, err := loadChartDependencies(absChartYAMLPath)
 		if err != nil {
 			return nil, nil, fmt.Errorf("loading dependencies for chart: %w", err)
 		}
 		for i, dependency := range chartDependencies {
-			chartKey := path.Join(dependency.Repository, dependency.Name)
+			chartKey := path.Join(
+				helm.NormalizeChartRepositoryURL(dependency.Repository),
+				dependency.Name,
+			)
 			version, found := versionsByChart[chartKey]
 			if !found {
 				continue
@@ -255,9 +388,12 @@ func prepareDependencyCredentialsFn(
 			var ok bool
 			var repository string
 
-			switch {
-			case strings.HasPrefix(dependency.Repository, "https://"):
-				repository = dependency.Repository
+			// Dependencies are matched to Freight without regard to case, so the
+			// scheme must be recognized the same way here. Otherwise, a matched
+			// dependency on OCI://... would never be logged in to.
+			switch scheme := strings.ToLower(dependency.Repository); {
+			case strings.HasPrefix(scheme, "https://"):
+				repository = "https://" + dependency.Repository[len("https://"):]
 				if creds, ok, err = db.Get(ctx, namespace, credentials.TypeHelm, repository); err != nil {
 					return fmt.Errorf(
 						"obtaining credentials for chart repository %q: %w",
@@ -265,10 +401,10 @@ func prepareDependencyCredentialsFn(
 						err,
 					)
 				}
-			case strings.HasPrefix(dependency.Repository, "oci://"):
+			case strings.HasPrefix(scheme, "oci://"):
 				// NB: We log in to the OCI registry using the repository URL,
 				// and not the full chart reference.
-				repository = dependency.Repository
+				repository = "oci://" + helm.NormalizeChartRepositoryURL(dependency.Repository)
 				if creds, ok, err = db.Get(
 					ctx,
 					namespace,
@@ -81,21 +81,67 @@ func TestHelmerApply(t *testing.T) {
 	const testValue = "fake-value"
 	testCases := []struct {
 		name       string
+		update     *kargoapi.HelmPromotionMechanism
 		helmer     *helmer
 		assertions func(t *testing.T, changes []string, err error)
 	}{
+		{
+			name: "values file path escapes working directory",
+			update: &kargoapi.HelmPromotionMechanism{
+				Images: []kargoapi.HelmImageUpdate{
+					{
+						ValuesFilePath: "../escape/values.yaml",
+					},
+				},
+			},
+			helmer: &helmer{},
+			assertions: func(t *testing.T, _ []string, err error) {
+				require.ErrorContains(t, err, "invalid values file path")
+				require.ErrorContains(t, err, "falls outside of the working directory")
+			},
+		},
+		{
+			name: "chart path is absolute",
+			update: &kargoapi.HelmPromotionMechanism{
+				Charts: []kargoapi.HelmChartDependencyUpdate{
+					{
+						ChartPath: "/etc/foo",
+					},
+				},
+			},
+			helmer: &helmer{},
+			assertions: func(t *testing.T, _ []string, err error) {
+				require.ErrorContains(t, err, "invalid chart path")
+				require.ErrorContains(t, err, "is absolute")
+			},
+		},
+		{
+			name: "error building values file changes",
+			helmer: &helmer{
+				buildValuesFilesChangesFn: func(
+					[]kargoapi.Image,
+					[]kargoapi.HelmImageUpdate,
+				) (map[string]map[string]string, []string, error) {
+					return nil, nil, errors.New("something went wrong")
+				},
+			},
+			assertions: func(t *testing.T, _ []string, err error) {
+				require.ErrorContains(t, err, "preparing changes to affected values files")
+				require.ErrorContains(t, err, "something went wrong")
+			},
+		},
 		{
 			name: "error updating values file",
 			helmer: &helmer{
 				buildValuesFilesChangesFn: func(
 					[]kargoapi.Image,
 					[]kargoapi.HelmImageUpdate,
-				) (map[string]map[string]string, []string) {
+				) (map[string]map[string]string, []string, error) {
 					return map[string]map[string]string{
 						testValuesFile: {
 							testKey: testValue,
 						},
-					}, nil
+					}, nil, nil
 				},
 				setStringsInYAMLFileFn: func(string, map[string]string) error {
 					return errors.New("something went wrong")
@@ -112,11 +158,11 @@ func TestHelmerApply(t *testing.T) {
 				buildValuesFilesChangesFn: func(
 					[]kargoapi.Image,
 					[]kargoapi.HelmImageUpdate,
-				) (map[string]map[string]string, []string) {
+				) (map[string]map[string]string, []string, error) {
 					// This returns nothing so that the only calls to
 					// setStringsInYAMLFileFn will be for updating subcharts in
 					// Charts.yaml.
-					return nil, nil
+					return nil, nil, nil
 				},
 				buildChartDependencyChangesFn: func(
 					string,
@@ -137,11 +183,11 @@ func TestHelmerApply(t *testing.T) {
 				buildValuesFilesChangesFn: func(
 					[]kargoapi.Image,
 					[]kargoapi.HelmImageUpdate,
-				) (map[string]map[string]string, []string) {
+				) (map[string]map[string]string, []string, error) {
 					// This returns nothing so that the only calls to
 					// setStringsInYAMLFileFn will be for updating subcharts in
 					// Charts.yaml.
-					return nil, nil
+					return nil, nil, nil
 				},
 				buildChartDependencyChangesFn: func(
 					string,
@@ -169,8 +215,8 @@ func TestHelmerApply(t *testing.T) {
 				buildValuesFilesChangesFn: func(
 					[]kargoapi.Image,
 					[]kargoapi.HelmImageUpdate,
-				) (map[string]map[string]string, []string) {
-					return nil, nil
+				) (map[string]map[string]string, []string, error) {
+					return nil, nil, nil
 				},
 				prepareDependencyCredentialsFn: func(context.Context, string, string, string) error {
 					return fmt.Errorf("something went wrong")
@@ -201,8 +247,8 @@ func TestHelmerApply(t *testing.T) {
 				buildValuesFilesChangesFn: func(
 					[]kargoapi.Image,
 					[]kargoapi.HelmImageUpdate,
-				) (map[string]map[string]string, []string) {
-					return nil, nil
+				) (map[string]map[string]string, []string, error) {
+					return nil, nil, nil
 				},
 				prepareDependencyCredentialsFn: func(context.Context, string, string, string) error {
 					return nil
@@ -236,12 +282,12 @@ func TestHelmerApply(t *testing.T) {
 				buildValuesFilesChangesFn: func(
 					[]kargoapi.Image,
 					[]kargoapi.HelmImageUpdate,
-				) (map[string]map[string]string, []string) {
+				) (map[string]map[string]string, []string, error) {
 					return map[string]map[string]string{
 						testValuesFile: {
 							testKey: testValue,
 						},
-					}, []string{"fake-image-update"}
+					}, []string{"fake-image-update"}, nil
 				},
 				buildChartDependencyChangesFn: func(
 					string,
@@ -272,16 +318,20 @@ func TestHelmerApply(t *testing.T) {
 	}
 	for _, testCase := range testCases {
 		t.Run(testCase.name, func(t *testing.T) {
+			update := testCase.update
+			if update == nil {
+				update = &kargoapi.HelmPromotionMechanism{}
+			}
 			changes, err := testCase.helmer.apply(
 				context.TODO(),
 				kargoapi.GitRepoUpdate{
-					Helm: &kargoapi.HelmPromotionMechanism{},
+					Helm: update,
 				},
 				kargoapi.FreightReference{}, // The way the tests are structured, this value doesn't matter
 				"",
 				"",
 				"",
-				"",
+				t.TempDir(),
 				git.RepoCredentials{},
 			)
 			testCase.assertions(t, changes, err)
@@ -289,86 +339,427 @@ func TestHelmerApply(t *testing.T) {
 	}
 }
 
-func TestBuildValuesFilesChanges(t *testing.T) {
-	images := []kargoapi.Image{
+func TestSecurePath(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(t, err)
+	outside := t.TempDir()
+
+	// A symlink that stays within the root
+	err = os.WriteFile(filepath.Join(root, "values.yaml"), []byte{}, 0600)
+	require.NoError(t, err)
+	err = os.Symlink("values.yaml", filepath.Join(root, "linked-values.yaml"))
+	require.NoError(t, err)
+	// Symlinks to a file and to a directory outside the root
+	err = os.WriteFile(filepath.Join(outside, "values.yaml"), []byte{}, 0600)
+	require.NoError(t, err)
+	err = os.Symlink(
+		filepath.Join(outside, "values.yaml"),
+		filepath.Join(root, "escaping-values.yaml"),
+	)
+	require.NoError(t, err)
+	err = os.Symlink(outside, filepath.Join(root, "escaping-dir"))
+	require.NoError(t, err)
+	// A symlink to a path that does not exist
+	err = os.Symlink(
+		filepath.Join(outside, "nonexistent.yaml"),
+		filepath.Join(root, "dangling-values.yaml"),
+	)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name       string
+		path       string
+		assertions func(*testing.T, string, error)
+	}{
 		{
-			RepoURL: "fake-url",
-			Tag:     "fake-tag",
-			Digest:  "fake-digest",
+			name: "absolute path",
+			path: "/etc/passwd",
+			assertions: func(t *testing.T, _ string, err error) {
+				require.ErrorContains(t, err, "is absolute")
+			},
 		},
 		{
-			RepoURL: "second-fake-url",
-			Tag:     "second-fake-tag",
-			Digest:  "second-fake-digest",
+			name: "path escapes root",
+			path: "../escape",
+			assertions: func(t *testing.T, _ string, err error) {
+				require.ErrorContains(t, err, "falls outside of the working directory")
+			},
 		},
 		{
-			RepoURL: "third-fake-url",
-			Tag:     "third-fake-tag",
-			Digest:  "third-fake-digest",
+			name: "path escapes root after descending",
+			path: "charts/../../escape",
+			assertions: func(t *testing.T, _ string, err error) {
+				require.ErrorContains(t, err, "falls outside of the working directory")
+			},
 		},
 		{
-			RepoURL: "fourth-fake-url",
-			Tag:     "fourth-fake-tag",
-			Digest:  "fourth-fake-digest",
+			name: "path stays within root",
+			path: "charts/../values..yaml",
+			assertions: func(t *testing.T, p string, err error) {
+				require.NoError(t, err)
+				require.Equal(t, filepath.Join(root, "values..yaml"), p)
+			},
 		},
-	}
-	imageUpdates := []kargoapi.HelmImageUpdate{
 		{
-			ValuesFilePath: "fake-values.yaml",
-			Image:          "fake-url",
-			Key:            "fake-key",
-			Value:          kargoapi.ImageUpdateValueTypeImageAndTag,
+			name: "symlink stays within root",
+			path: "linked-values.yaml",
+			assertions: func(t *testing.T, p string, err error) {
+				require.NoError(t, err)
+				require.Equal(t, filepath.Join(root, "values.yaml"), p)
+			},
 		},
 		{
-			ValuesFilePath: "fake-values.yaml",
-			Image:          "second-fake-url",
-			Key:            "second-fake-key",
-			Value:          kargoapi.ImageUpdateValueTypeTag,
+			name: "symlinked file escapes root",
+			path: "escaping-values.yaml",
+			assertions: func(t *testing.T, _ string, err error) {
+				require.ErrorContains(t, err, "falls outside of the working directory")
+			},
 		},
 		{
-			ValuesFilePath: "another-fake-values.yaml",
-			Image:          "third-fake-url",
-			Key:            "third-fake-key",
-			Value:          kargoapi.ImageUpdateValueTypeImageAndDigest,
+			name: "symlinked directory escapes root",
+			path: "escaping-dir/values.yaml",
+			assertions: func(t *testing.T, _ string, err error) {
+				require.ErrorContains(t, err, "falls outside of the working directory")
+			},
 		},
 		{
-			ValuesFilePath: "another-fake-values.yaml",
-			Image:          "fourth-fake-url",
-			Key:            "fourth-fake-key",
-			Value:          kargoapi.ImageUpdateValueTypeDigest,
+			name: "symlink to nonexistent path",
+			path: "dangling-values.yaml",
+			assertions: func(t *testing.T, _ string, err error) {
+				require.ErrorContains(t, err, "symlink to a path that does not exist")
+			},
 		},
 		{
-			ValuesFilePath: "yet-another-fake-values.yaml",
-			Image:          "image-that-is-not-in-list",
-			Key:            "fake-key",
-			Value:          "Tag",
+			name: "path does not exist yet",
+			path: "charts/foo/Chart.yaml",
+			assertions: func(t *testing.T, p string, err error) {
+				require.NoError(t, err)
+				require.Equal(t, filepath.Join(root, "charts", "foo", "Chart.yaml"), p)
+			},
 		},
 	}
-	result, changeSummary := buildValuesFilesChanges(images, imageUpdates)
-	require.Equal(
-		t,
-		map[string]map[string]string{
-			"fake-values.yaml": {
-				"fake-key":        "fake-url:fake-tag",
-				"second-fake-key": "'second-fake-tag'",
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			p, err := securePath(root, testCase.path)
+			testCase.assertions(t, p, err)
+		})
+	}
+}
+
+func TestBuildValuesFilesChanges(t *testing.T) {
+	const testDigest = "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	// Some registries report digests in mixed case
+	const testMixedCaseDigest = "SHA256:5F0AF5CD810D835B3E0F1565A0F270C05487C6C871FED97D3F999DD1F1F84C3B"
+	const testNormalizedDigest = "sha256:5f0af5cd810d835b3e0f1565a0f270c05487c6c871fed97d3f999dd1f1f84c3b"
+	testCases := []struct {
+		name         string
+		images       []kargoapi.Image
+		imageUpdates []kargoapi.HelmImageUpdate
+		assertions   func(*testing.T, map[string]map[string]string, []string, error)
+	}{
+		{
+			name: "success",
+			images: []kargoapi.Image{
+				{
+					RepoURL: "fake-url",
+					Tag:     "fake-tag",
+					Digest:  "fake-digest",
+				},
+				{
+					RepoURL: "second-fake-url",
+					Tag:     "second-fake-tag",
+					Digest:  "second-fake-digest",
+				},
+				{
+					RepoURL: "third-fake-url",
+					Tag:     "third-fake-tag",
+					Digest:  testDigest,
+				},
+				{
+					RepoURL: "fourth-fake-url",
+					Tag:     "fourth-fake-tag",
+					Digest:  testMixedCaseDigest,
+				},
+			},
+			imageUpdates: []kargoapi.HelmImageUpdate{
+				{
+					ValuesFilePath: "fake-values.yaml",
+					Image:          "fake-url",
+					Key:            "fake-key",
+					Value:          kargoapi.ImageUpdateValueTypeImageAndTag,
+				},
+				{
+					ValuesFilePath: "fake-values.yaml",
+					Image:          "second-fake-url",
+					Key:            "second-fake-key",
+					Value:          kargoapi.ImageUpdateValueTypeTag,
+				},
+				{
+					ValuesFilePath: "another-fake-values.yaml",
+					Image:          "third-fake-url",
+					Key:            "third-fake-key",
+					Value:          kargoapi.ImageUpdateValueTypeImageAndDigest,
+				},
+				{
+					ValuesFilePath: "another-fake-values.yaml",
+					Image:          "fourth-fake-url",
+					Key:            "fourth-fake-key",
+					Value:          kargoapi.ImageUpdateValueTypeDigest,
+				},
+				{
+					ValuesFilePath: "yet-another-fake-values.yaml",
+					Image:          "image-that-is-not-in-list",
+					Key:            "fake-key",
+					Value:          "Tag",
+				},
+			},
+			assertions: func(
+				t *testing.T,
+				result map[string]map[string]string,
+				changeSummary []string,
+				err error,
+			) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					map[string]map[string]string{
+						"fake-values.yaml": {
+							"fake-key":        "fake-url:fake-tag",
+							"second-fake-key": "'second-fake-tag'",
+						},
+						"another-fake-values.yaml": {
+							"third-fake-key":  "third-fake-url@" + testDigest,
+							"fourth-fake-key": testNormalizedDigest,
+						},
+					},
+					result,
+				)
+				require.Equal(
+					t,
+					[]string{
+						"updated fake-values.yaml to use image fake-url:fake-tag",
+						"updated fake-values.yaml to use image second-fake-url:second-fake-tag",
+						"updated another-fake-values.yaml to use image third-fake-url@" + testDigest,
+						// Digests should have been normalized to lowercase
+						"updated another-fake-values.yaml to use image fourth-fake-url@" +
+							testNormalizedDigest,
+					},
+					changeSummary,
+				)
+			},
+		},
+		{
+			name: "digest called for, but only tag is known",
+			images: []kargoapi.Image{
+				{
+					RepoURL: "fake-url",
+					Tag:     "fake-tag",
+				},
+			},
+			imageUpdates: []kargoapi.HelmImageUpdate{
+				{
+					ValuesFilePath: "fake-values.yaml",
+					Image:          "fake-url",
+					Key:            "fake-key",
+					Value:          kargoapi.ImageUpdateValueTypeImageAndDigest,
+				},
 			},
-			"another-fake-values.yaml": {
-				"third-fake-key":  "third-fake-url@third-fake-digest",
-				"fourth-fake-key": "fourth-fake-digest",
+			assertions: func(t *testing.T, _ map[string]map[string]string, _ []string, err error) {
+				require.ErrorContains(t, err, `cannot update key "fake-key" in fake-values.yaml`)
+				require.ErrorContains(t, err, `no digest is known for image "fake-url"`)
 			},
 		},
-		result,
-	)
-	require.Equal(
-		t,
-		[]string{
-			"updated fake-values.yaml to use image fake-url:fake-tag",
-			"updated fake-values.yaml to use image second-fake-url:second-fake-tag",
-			"updated another-fake-values.yaml to use image third-fake-url@third-fake-digest",
-			"updated another-fake-values.yaml to use image fourth-fake-url@fourth-fake-digest",
+		{
+			name: "digest called for, but known digest is malformed",
+			images: []kargoapi.Image{
+				{
+					RepoURL: "fake-url",
+					Digest:  "not a digest",
+				},
+			},
+			imageUpdates: []kargoapi.HelmImageUpdate{
+				{
+					ValuesFilePath: "fake-values.yaml",
+					Image:          "fake-url",
+					Key:            "fake-key",
+					Value:          kargoapi.ImageUpdateValueTypeDigest,
+				},
+			},
+			assertions: func(t *testing.T, _ map[string]map[string]string, _ []string, err error) {
+				require.ErrorContains(t, err, `cannot update key "fake-key" in fake-values.yaml`)
+				require.ErrorContains(t, err, `digest "not a digest" for image "fake-url" is malformed`)
+			},
 		},
-		changeSummary,
-	)
+		{
+			name: "digest called for, but known digest is not hex",
+			images: []kargoapi.Image{
+				{
+					RepoURL: "fake-url",
+					Digest:  "sha256:xyz",
+				},
+			},
+			imageUpdates: []kargoapi.HelmImageUpdate{
+				{
+					ValuesFilePath: "fake-values.yaml",
+					Image:          "fake-url",
+					Key:            "fake-key",
+					Value:          kargoapi.ImageUpdateValueTypeDigest,
+				},
+			},
+			assertions: func(t *testing.T, _ map[string]map[string]string, _ []string, err error) {
+				require.ErrorContains(t, err, `digest "sha256:xyz" for image "fake-url" is malformed`)
+			},
+		},
+		{
+			name: "digest called for, but known digest is truncated",
+			images: []kargoapi.Image{
+				{
+					RepoURL: "fake-url",
+					Digest:  "sha256:0123",
+				},
+			},
+			imageUpdates: []kargoapi.HelmImageUpdate{
+				{
+					ValuesFilePath: "fake-values.yaml",
+					Image:          "fake-url",
+					Key:            "fake-key",
+					Value:          kargoapi.ImageUpdateValueTypeDigest,
+				},
+			},
+			assertions: func(t *testing.T, _ map[string]map[string]string, _ []string, err error) {
+				require.ErrorContains(t, err, `digest "sha256:0123" for image "fake-url" is malformed`)
+			},
+		},
+		{
+			name: "digest called for, but known digest uses unsupported algorithm",
+			images: []kargoapi.Image{
+				{
+					RepoURL: "fake-url",
+					Digest:  "md5:1",
+				},
+			},
+			imageUpdates: []kargoapi.HelmImageUpdate{
+				{
+					ValuesFilePath: "fake-values.yaml",
+					Image:          "fake-url",
+					Key:            "fake-key",
+					Value:          kargoapi.ImageUpdateValueTypeDigest,
+				},
+			},
+			assertions: func(t *testing.T, _ map[string]map[string]string, _ []string, err error) {
+				require.ErrorContains(t, err, `digest "md5:1" for image "fake-url" is malformed`)
+			},
+		},
+		{
+			name: "tag called for, but neither tag nor digest is known",
+			images: []kargoapi.Image{
+				{
+					RepoURL: "fake-url",
+				},
+			},
+			imageUpdates: []kargoapi.HelmImageUpdate{
+				{
+					ValuesFilePath: "fake-values.yaml",
+					Image:          "fake-url",
+					Key:            "fake-key",
+					Value:          kargoapi.ImageUpdateValueTypeTag,
+				},
+			},
+			assertions: func(t *testing.T, _ map[string]map[string]string, _ []string, err error) {
+				require.ErrorContains(t, err, `cannot update key "fake-key" in fake-values.yaml`)
+				require.ErrorContains(t, err, `no tag is known for image "fake-url"`)
+			},
+		},
+		{
+			name: "all failed updates are reported",
+			images: []kargoapi.Image{
+				{
+					RepoURL: "fake-url",
+					Tag:     "fake-tag",
+				},
+				{
+					RepoURL: "second-fake-url",
+					Digest:  "second-fake-digest",
+				},
+				{
+					RepoURL: "third-fake-url",
+					Tag:     "third-fake-tag",
+				},
+			},
+			imageUpdates: []kargoapi.HelmImageUpdate{
+				{
+					ValuesFilePath: "fake-values.yaml",
+					Image:          "fake-url",
+					Key:            "fake-key",
+					Value:          kargoapi.ImageUpdateValueTypeTag,
+				},
+				{
+					ValuesFilePath: "fake-values.yaml",
+					Image:          "second-fake-url",
+					Key:            "second-fake-key",
+					Value:          kargoapi.ImageUpdateValueTypeTag,
+				},
+				{
+					ValuesFilePath: "fake-values.yaml",
+					Image:          "third-fake-url",
+					Key:            "third-fake-key",
+					Value:          kargoapi.ImageUpdateValueTypeDigest,
+				},
+			},
+			assertions: func(t *testing.T, _ map[string]map[string]string, _ []string, err error) {
+				require.ErrorContains(t, err, `cannot update key "second-fake-key"`)
+				require.ErrorContains(t, err, `cannot update key "third-fake-key"`)
+				require.NotContains(t, err.Error(), `"fake-key"`)
+			},
+		},
+		{
+			name: "registry host with port",
+			images: []kargoapi.Image{
+				{
+					RepoURL: "localhost:5000/nginx",
+					Tag:     "1.19.0",
+				},
+			},
+			imageUpdates: []kargoapi.HelmImageUpdate{
+				{
+					ValuesFilePath: "fake-values.yaml",
+					Image:          "localhost:5000/nginx",
+					Key:            "image",
+					Value:          kargoapi.ImageUpdateValueTypeImageAndTag,
+				},
+			},
+			assertions: func(
+				t *testing.T,
+				result map[string]map[string]string,
+				changeSummary []string,
+				err error,
+			) {
+				require.NoError(t, err)
+				// The port must not have been mistaken for a tag
+				require.Equal(
+					t,
+					map[string]map[string]string{
+						"fake-values.yaml": {
+							"image": "localhost:5000/nginx:1.19.0",
+						},
+					},
+					result,
+				)
+				require.Equal(
+					t,
+					[]string{"updated fake-values.yaml to use image localhost:5000/nginx:1.19.0"},
+					changeSummary,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			result, changeSummary, err :=
+				buildValuesFilesChanges(testCase.images, testCase.imageUpdates)
+			testCase.assertions(t, result, changeSummary, err)
+		})
+	}
 }
 
 func TestBuildChartDependencyChanges(t *testing.T) {
@@ -413,6 +804,23 @@ func TestBuildChartDependencyChanges(t *testing.T) {
 	)
 	require.NoError(t, err)
 
+	testBazChartDir := filepath.Join(testChartsDir, "baz")
+	err = os.Mkdir(testBazChartDir, 0755)
+	require.NoError(t, err)
+
+	err = os.WriteFile(
+		filepath.Join(testBazChartDir, "Chart.yaml"),
+		// This fake chart's dependency is in an OCI repository, referenced in a
+		// form that differs from the Freight's only by case and a trailing slash
+		[]byte(`dependencies:
+- repository: OCI://ghcr.io/Example/Charts/
+  name: baz-chart
+  version: placeholder
+`),
+		0600,
+	)
+	require.NoError(t, err)
+
 	// New charts
 	charts := []kargoapi.Chart{
 		{
@@ -425,6 +833,11 @@ func TestBuildChartDependencyChanges(t *testing.T) {
 			Name:    "another-fake-chart",
 			Version: "another-fake-version",
 		},
+		{
+			// Charts from OCI repositories are identified by URL alone
+			RepoURL: "oci://ghcr.io/example/charts/baz-chart",
+			Version: "baz-version",
+		},
 	}
 
 	// Instructions for how to update Chart.yaml files
@@ -441,6 +854,11 @@ func TestBuildChartDependencyChanges(t *testing.T) {
 		},
 		// Note there is no mention of how to update bar's second dependency, so
 		// we expect it to be left alone.
+		{
+			Repository: "oci://ghcr.io/example/charts",
+			Name:       "baz-chart",
+			ChartPath:  "charts/baz",
+		},
 	}
 
 	result, changeSummary, err :=
@@ -455,18 +873,106 @@ func TestBuildChartDependencyChanges(t *testing.T) {
 			"charts/bar": {
 				"dependencies.0.version": "another-fake-version",
 			},
+			"charts/baz": {
+				"dependencies.0.version": "baz-version",
+			},
 		},
 		result,
 	)
-	require.Contains(
-		t,
-		changeSummary,
-		"updated charts/foo/Chart.yaml to use subchart fake-chart:fake-version",
-	)
-	require.Contains(
+	// Changes should be summarized in order of chart path
+	require.Equal(
 		t,
+		[]string{
+			"updated charts/bar/Chart.yaml to use subchart " +
+				"another-fake-chart:another-fake-version",
+			"updated charts/baz/Chart.yaml to use subchart baz-chart:baz-version",
+			"updated charts/foo/Chart.yaml to use subchart fake-chart:fake-version",
+		},
 		changeSummary,
-		"updated charts/bar/Chart.yaml to use subchart "+
-			"another-fake-chart:another-fake-version",
 	)
 }
+
+func TestPrepareDependencyCredentialsFn(t *testing.T) {
+	testCases := []struct {
+		name       string
+		repository string
+		assertions func(*testing.T, []string, error)
+	}{
+		{
+			name:       "https repository",
+			repository: "https://charts.example.com/Stable",
+			assertions: func(t *testing.T, lookedUp []string, err error) {
+				require.NoError(t, err)
+				require.Equal(t, []string{"https://charts.example.com/Stable"}, lookedUp)
+			},
+		},
+		{
+			name:       "https repository with uppercase scheme",
+			repository: "HTTPS://charts.example.com/Stable",
+			assertions: func(t *testing.T, lookedUp []string, err error) {
+				require.NoError(t, err)
+				require.Equal(t, []string{"https://charts.example.com/Stable"}, lookedUp)
+			},
+		},
+		{
+			name:       "OCI repository",
+			repository: "oci://ghcr.io/example/charts",
+			assertions: func(t *testing.T, lookedUp []string, err error) {
+				require.NoError(t, err)
+				require.Equal(t, []string{"oci://ghcr.io/example/charts/fake-chart"}, lookedUp)
+			},
+		},
+		{
+			name:       "OCI repository with uppercase scheme",
+			repository: "OCI://ghcr.io/Example/Charts",
+			assertions: func(t *testing.T, lookedUp []string, err error) {
+				require.NoError(t, err)
+				require.Equal(t, []string{"oci://ghcr.io/example/charts/fake-chart"}, lookedUp)
+			},
+		},
+		{
+			name:       "unsupported repository",
+			repository: "file://../fake-chart",
+			assertions: func(t *testing.T, lookedUp []string, err error) {
+				require.NoError(t, err)
+				require.Empty(t, lookedUp)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			chartPath := filepath.Join(t.TempDir(), "Chart.yaml")
+			err := os.WriteFile(
+				chartPath,
+				[]byte(fmt.Sprintf(`dependencies:
+- repository: %s
+  name: fake-chart
+  version: placeholder
+`, testCase.repository)),
+				0600,
+			)
+			require.NoError(t, err)
+
+			var lookedUp []string
+			db := &credentials.FakeDB{
+				GetFn: func(
+					_ context.Context,
+					_ string,
+					_ credentials.Type,
+					repo string,
+				) (credentials.Credentials, bool, error) {
+					lookedUp = append(lookedUp, repo)
+					// No credentials are found, so no login is attempted
+					return credentials.Credentials{}, false, nil
+				},
+			}
+			err = prepareDependencyCredentialsFn(db)(
+				context.Background(),
+				t.TempDir(),
+				chartPath,
+				"fake-namespace",
+			)
+			testCase.assertions(t, lookedUp, err)
+		})
+	}
+}
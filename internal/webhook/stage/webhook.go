@@ -3,6 +3,7 @@ package stage
 import (
 	"context"
 	"fmt"
+	"path"
 
 	admissionv1 "k8s.io/api/admission/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
@@ -15,6 +16,7 @@ import (
 
 	kargoapi "github.com/akuity/kargo/api/v1alpha1"
 	libWebhook "github.com/akuity/kargo/internal/webhook"
+	libYAML "github.com/akuity/kargo/internal/yaml"
 )
 
 var (
@@ -322,5 +324,30 @@ func (w *webhook) validateHelmPromotionMechanism(
 			),
 		}
 	}
-	return nil
+	// No two image updates may target the same key in the same values file,
+	// as only one of them could ever take effect
+	var errs field.ErrorList
+	type valuesFileKey struct {
+		path string
+		key  string
+	}
+	keys := make(map[valuesFileKey]struct{}, len(promoMech.Images))
+	for i, image := range promoMech.Images {
+		// Normalize the path and key so that, e.g., ./values.yaml and
+		// values.yaml are recognized as the same file, and containers[0].image
+		// and containers.0.image are recognized as the same key
+		k := valuesFileKey{
+			path: path.Clean(image.ValuesFilePath),
+			key:  libYAML.NormalizeKey(image.Key),
+		}
+		if _, found := keys[k]; found {
+			errs = append(
+				errs,
+				field.Duplicate(f.Child("images").Index(i).Child("key"), image.Key),
+			)
+			continue
+		}
+		keys[k] = struct{}{}
+	}
+	return errs
 }
@@ -1390,6 +1390,120 @@ func TestValidateHelmPromotionMechanism(t *testing.T) {
 			},
 		},
 
+		{
+			name: "duplicate key in the same values file",
+			promoMech: &kargoapi.HelmPromotionMechanism{
+				Images: []kargoapi.HelmImageUpdate{
+					{
+						Image:          "docker.io/library/nginx",
+						ValuesFilePath: "values.yaml",
+						Key:            "image.tag",
+					},
+					{
+						Image:          "docker.io/library/busybox",
+						ValuesFilePath: "values.yaml",
+						Key:            "image.tag",
+					},
+				},
+			},
+			assertions: func(t *testing.T, _ *kargoapi.HelmPromotionMechanism, errs field.ErrorList) {
+				require.Equal(
+					t,
+					field.ErrorList{
+						{
+							Type:     field.ErrorTypeDuplicate,
+							Field:    "helm.images[1].key",
+							BadValue: "image.tag",
+						},
+					},
+					errs,
+				)
+			},
+		},
+
+		{
+			name: "duplicate key in equivalent values file paths",
+			promoMech: &kargoapi.HelmPromotionMechanism{
+				Images: []kargoapi.HelmImageUpdate{
+					{
+						Image:          "docker.io/library/nginx",
+						ValuesFilePath: "values.yaml",
+						Key:            "image.tag",
+					},
+					{
+						Image:          "docker.io/library/busybox",
+						ValuesFilePath: "./values.yaml",
+						Key:            "image.tag",
+					},
+				},
+			},
+			assertions: func(t *testing.T, _ *kargoapi.HelmPromotionMechanism, errs field.ErrorList) {
+				require.Equal(
+					t,
+					field.ErrorList{
+						{
+							Type:     field.ErrorTypeDuplicate,
+							Field:    "helm.images[1].key",
+							BadValue: "image.tag",
+						},
+					},
+					errs,
+				)
+			},
+		},
+
+		{
+			name: "duplicate key in equivalent forms",
+			promoMech: &kargoapi.HelmPromotionMechanism{
+				Images: []kargoapi.HelmImageUpdate{
+					{
+						Image:          "docker.io/library/nginx",
+						ValuesFilePath: "values.yaml",
+						Key:            "containers[0].image",
+					},
+					{
+						Image:          "docker.io/library/busybox",
+						ValuesFilePath: "values.yaml",
+						Key:            "containers.0.image",
+					},
+				},
+			},
+			assertions: func(t *testing.T, _ *kargoapi.HelmPromotionMechanism, errs field.ErrorList) {
+				require.Equal(
+					t,
+					field.ErrorList{
+						{
+							Type:     field.ErrorTypeDuplicate,
+							Field:    "helm.images[1].key",
+							BadValue: "containers.0.image",
+						},
+					},
+					errs,
+				)
+			},
+		},
+
+		{
+			name: "same key in different values files",
+			promoMech: &kargoapi.HelmPromotionMechanism{
+				Images: []kargoapi.HelmImageUpdate{
+					{
+						Image:          "docker.io/library/nginx",
+						ValuesFilePath: "env/dev/values.yaml",
+						Key:            "image.tag",
+					},
+					{
+						Image:          "docker.io/library/nginx",
+						ValuesFilePath: "env/prod/values.yaml",
+						Key:            "image.tag",
+					},
+				},
+			},
+			assertions: func(t *testing.T, _ *kargoapi.HelmPromotionMechanism, errs field.ErrorList) {
+				require.Empty(t, errs)
+			},
+		},
+
 		{
 			name: "valid",
 			promoMech: &kargoapi.HelmPromotionMechanism{
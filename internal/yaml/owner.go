@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package yaml
+
+import (
+	"os"
+	"syscall"
+)
+
+// copyOwner sets the owner and group of the provided file to those described
+// by the provided FileInfo.
+func copyOwner(f *os.File, fileInfo os.FileInfo) error {
+	stat, ok := fileInfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return f.Chown(int(stat.Uid), int(stat.Gid))
+}
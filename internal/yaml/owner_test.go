@@ -0,0 +1,31 @@
+//go:build !windows
+// +build !windows
+
+package yaml
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFileAtomicallyPreservesOwner(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing a file's owner requires root")
+	}
+	file := filepath.Join(t.TempDir(), "values.yaml")
+	require.NoError(t, os.WriteFile(file, []byte("foo: bar\n"), 0600))
+	require.NoError(t, os.Chown(file, 65534, 65534))
+
+	require.NoError(t, writeFileAtomically(file, []byte("foo: baz\n")))
+
+	fi, err := os.Stat(file)
+	require.NoError(t, err)
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	require.True(t, ok)
+	require.Equal(t, uint32(65534), stat.Uid)
+	require.Equal(t, uint32(65534), stat.Gid)
+}
@@ -0,0 +1,9 @@
+package yaml
+
+import "os"
+
+// copyOwner is a no-op on Windows, where files do not have a Unix owner and
+// group.
+func copyOwner(*os.File, os.FileInfo) error {
+	return nil
+}
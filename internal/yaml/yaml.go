@@ -1,10 +1,13 @@
 package yaml
 
 import (
-	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -14,10 +17,13 @@ import (
 // SetStringsInFile overwrites the specified file with the changes specified by
 // the changes map applied. The changes map maps keys to new values. Keys are of
 // the form <key 0>.<key 1>...<key n>. Integers may be used as keys in cases
-// where a specific node needs to be selected from a sequence. Individual
-// changes are ignored without error if their key is not found or if their key
-// is found not to address a scalar node. Importantly, all comments and style
-// choices in the input bytes are preserved in the output.
+// where a specific node needs to be selected from a sequence, either as their
+// own key (e.g. containers.0.image) or in brackets (e.g. containers[0].image).
+// Individual changes are ignored without error if their key is not found or if
+// their key is found not to address a scalar node, but an error is returned if
+// their key contains an index that is out of range for its sequence.
+// Importantly, all comments and style choices in the input bytes are preserved
+// in the output.
 func SetStringsInFile(file string, changes map[string]string) error {
 	inBytes, err := os.ReadFile(file)
 	if err != nil {
@@ -31,12 +37,7 @@ func SetStringsInFile(file string, changes map[string]string) error {
 	if err != nil {
 		return fmt.Errorf("error mutating bytes: %w", err)
 	}
-
-	// This file should always exist already, so the permissions we choose here
-	// don't really matter. (They only matter when this function call creates
-	// the file, which it never will.) We went with 0600 just to appease the
-	// gosec linter.
-	if err = os.WriteFile(file, outBytes, 0600); err != nil {
+	if err = writeFileAtomically(file, outBytes); err != nil {
 		return fmt.Errorf(
 			"error writing mutated bytes to file %q: %w",
 			file,
@@ -46,13 +47,62 @@ func SetStringsInFile(file string, changes map[string]string) error {
 	return nil
 }
 
+// writeFileAtomically replaces the contents of the specified file with the
+// provided bytes by first writing them to a temporary file in the same
+// directory and then renaming that over the original. This ensures the file
+// is never left partially written. The temporary file is removed if anything
+// goes wrong. If the specified file is a symlink, the file it points to is
+// the one replaced, so the symlink itself is left intact. The original file's
+// permissions and, where possible, its owner are carried over.
+func writeFileAtomically(file string, b []byte) (err error) {
+	target, err := filepath.EvalSymlinks(file)
+	if err != nil {
+		return fmt.Errorf("error resolving file: %w", err)
+	}
+	fileInfo, err := os.Stat(target)
+	if err != nil {
+		return fmt.Errorf("error getting file info: %w", err)
+	}
+	tmpFile, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".*")
+	if err != nil {
+		return fmt.Errorf("error creating temporary file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tmpFile.Close()
+			_ = os.Remove(tmpFile.Name())
+		}
+	}()
+	if _, err = tmpFile.Write(b); err != nil {
+		return fmt.Errorf("error writing to temporary file: %w", err)
+	}
+	if err = tmpFile.Chmod(fileInfo.Mode().Perm()); err != nil {
+		return fmt.Errorf("error setting permissions on temporary file: %w", err)
+	}
+	// Only a privileged process can give a file away to another user, so if
+	// that is what it would take, the file's owner cannot be preserved.
+	if err = copyOwner(tmpFile, fileInfo); err != nil && !errors.Is(err, os.ErrPermission) {
+		return fmt.Errorf("error setting owner of temporary file: %w", err)
+	}
+	if err = tmpFile.Close(); err != nil {
+		return fmt.Errorf("error closing temporary file: %w", err)
+	}
+	if err = os.Rename(tmpFile.Name(), target); err != nil {
+		return fmt.Errorf("error replacing file: %w", err)
+	}
+	return nil
+}
+
 // SetStringsInBytes returns a copy of the provided bytes with the changes
 // specified by the changes map applied. The changes map maps keys to new
 // values. Keys are of the form <key 0>.<key 1>...<key n>. Integers may be used
-// as keys in cases where a specific node needs to be selected from a sequence.
-// Individual changes are ignored without error if their key is not found or
-// if their key is found not to address a scalar node. Importantly, all comments
-// and style choices in the input bytes are preserved in the output.
+// as keys in cases where a specific node needs to be selected from a sequence,
+// either as their own key (e.g. containers.0.image) or in brackets (e.g.
+// containers[0].image). Individual changes are ignored without error if their
+// key is not found or if their key is found not to address a scalar node, but
+// an error is returned if their key contains an index that is out of range for
+// its sequence. Importantly, all comments and style choices in the input bytes
+// are preserved in the output.
 func SetStringsInBytes(
 	inBytes []byte,
 	changes map[string]string,
@@ -63,64 +113,166 @@ func SetStringsInBytes(
 	}
 
 	type change struct {
-		col   int
+		key   string
+		node  *yaml.Node
 		value string
 	}
-	changesByLine := map[int]change{}
+	// Several changes may fall on the same line, e.g. within a flow mapping
+	changesByLine := map[int][]change{}
 	for k, v := range changes {
-		keyPath := strings.Split(k, ".")
-		if found, line, col := findScalarNode(doc, keyPath); found {
-			changesByLine[line] = change{
-				col:   col,
-				value: v,
-			}
+		node, found, err := findScalarNode(doc, splitKey(k))
+		if err != nil {
+			return nil, fmt.Errorf("error resolving key %q: %w", k, err)
+		}
+		if found {
+			changesByLine[node.Line-1] = append(
+				changesByLine[node.Line-1],
+				change{key: k, node: node, value: v},
+			)
 		}
 	}
 
 	outBuf := &bytes.Buffer{}
 
-	scanner := bufio.NewScanner(bytes.NewBuffer(inBytes))
-	scanner.Split(bufio.ScanLines)
-	var line int
-	for scanner.Scan() {
+	// Each line keeps its own line ending. Files checked out on Windows
+	// commonly use CRLF, and some files mix CRLF and LF, so rewriting them with
+	// any one line ending would produce a whole-file diff.
+	for line, lineBytes := range bytes.SplitAfter(inBytes, []byte("\n")) {
 		const errMsg = "error writing to byte buffer"
-		change, found := changesByLine[line]
-		if !found {
-			if _, err := outBuf.WriteString(scanner.Text()); err != nil {
-				return nil, fmt.Errorf("%s: %w", errMsg, err)
-			}
-			if _, err := outBuf.WriteString("\n"); err != nil {
-				return nil, fmt.Errorf("%s: %w", errMsg, err)
-			}
-		} else {
-			unchanged := scanner.Text()[0:change.col]
-			if _, err := outBuf.WriteString(unchanged); err != nil {
-				return nil, fmt.Errorf("%s: %w", errMsg, err)
+		text := string(lineBytes)
+		var lineEnding string
+		switch {
+		case strings.HasSuffix(text, "\r\n"):
+			lineEnding = "\r\n"
+		case strings.HasSuffix(text, "\n"):
+			lineEnding = "\n"
+		}
+		text = strings.TrimSuffix(text, lineEnding)
+		// Apply changes from right to left so that the columns of those not yet
+		// applied remain accurate
+		lineChanges := changesByLine[line]
+		slices.SortFunc(lineChanges, func(a, b change) int {
+			return b.node.Column - a.node.Column
+		})
+		for _, change := range lineChanges {
+			start, end, err := scalarSpan(text, change.node)
+			if err != nil {
+				return nil, fmt.Errorf("error updating key %q: %w", change.key, err)
 			}
-			if !strings.HasSuffix(unchanged, " ") {
-				if _, err := outBuf.WriteString(" "); err != nil {
-					return nil, fmt.Errorf("%s: %w", errMsg, err)
+			value := change.value
+			if start == end {
+				// The old value was empty, so there may be nothing to separate the
+				// new value from what precedes or follows it
+				if start > 0 && !strings.ContainsAny(text[start-1:start], " \t") {
+					value = " " + value
+				}
+				if strings.HasPrefix(text[end:], "#") {
+					value += " "
 				}
 			}
-			if _, err := outBuf.WriteString(change.value); err != nil {
-				return nil, fmt.Errorf("%s: %w", errMsg, err)
+			text = text[:start] + value + text[end:]
+		}
+		if _, err := outBuf.WriteString(text); err != nil {
+			return nil, fmt.Errorf("%s: %w", errMsg, err)
+		}
+		if _, err := outBuf.WriteString(lineEnding); err != nil {
+			return nil, fmt.Errorf("%s: %w", errMsg, err)
+		}
+	}
+
+	return outBuf.Bytes(), nil
+}
+
+// scalarSpan returns the start and end of the provided scalar node's value
+// within the provided line, which must be the line on which the node begins.
+// Any anchor or tag preceding the value, and anything following it, such as a
+// comment or the remainder of a flow collection, falls outside the span. An
+// error is returned if the value does not fit on a single line.
+func scalarSpan(line string, node *yaml.Node) (int, int, error) {
+	i := node.Column - 1
+	// Skip over any properties (an anchor and/or a tag) and the whitespace
+	// around them. A plain scalar cannot begin with & or !, so these are
+	// unambiguous.
+	for {
+		for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+			i++
+		}
+		if i >= len(line) || (line[i] != '&' && line[i] != '!') {
+			break
+		}
+		for i < len(line) && !strings.ContainsRune(" \t,[]{}", rune(line[i])) {
+			i++
+		}
+	}
+	start := i
+	switch {
+	case node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0:
+		return 0, 0, fmt.Errorf("block scalars spanning multiple lines are not supported")
+	case node.Style&yaml.DoubleQuotedStyle != 0:
+		for i++; i < len(line); i++ {
+			switch line[i] {
+			case '\\':
+				i++
+			case '"':
+				return start, i + 1, nil
 			}
-			if _, err := outBuf.WriteString("\n"); err != nil {
-				return nil, fmt.Errorf("%s: %w", errMsg, err)
+		}
+	case node.Style&yaml.SingleQuotedStyle != 0:
+		for i++; i < len(line); i++ {
+			if line[i] == '\'' {
+				if i+1 < len(line) && line[i+1] == '\'' {
+					i++
+					continue
+				}
+				return start, i + 1, nil
 			}
 		}
-		line++
+	case node.Tag == "!!null" && node.Value == "":
+		// The value is implicitly empty
+		return start, start, nil
+	default:
+		// A plain scalar's value is exactly as written, unless it is folded
+		// across multiple lines
+		if strings.HasPrefix(line[start:], node.Value) {
+			return start, start + len(node.Value), nil
+		}
 	}
+	return 0, 0, fmt.Errorf("scalars spanning multiple lines are not supported")
+}
 
-	return outBuf.Bytes(), nil
+// bracketedIndexRegex matches a sequence index written in brackets, e.g. the
+// [0] in containers[0].image. Negative indices are matched too, so that they
+// are reported as out of range rather than mistaken for part of a key.
+var bracketedIndexRegex = regexp.MustCompile(`\[(-?\d+)\]`)
+
+// NormalizeKey returns the canonical form of a key of the form
+// <key 0>.<key 1>...<key n>, in which any sequence indices written in brackets
+// are instead written as keys of their own. Keys that address the same node,
+// e.g. containers[0].image and containers.0.image, have the same canonical
+// form.
+func NormalizeKey(key string) string {
+	return strings.Join(splitKey(key), ".")
 }
 
-func findScalarNode(node *yaml.Node, keyPath []string) (bool, int, int) {
+// splitKey splits a key of the form <key 0>.<key 1>...<key n> into its
+// individual parts. Bracketed sequence indices are treated as parts of their
+// own, so that a[0].b and a.0.b both yield [a 0 b]. Brackets that do not
+// enclose an integer are left alone and remain part of the key.
+func splitKey(key string) []string {
+	key = bracketedIndexRegex.ReplaceAllString(key, ".$1")
+	return strings.Split(key, ".")
+}
+
+// findScalarNode returns the scalar node addressed by the provided key path,
+// along with a boolean indicating whether such a node was found. An error is
+// returned if the key path contains an index that is out of range for the
+// sequence it addresses.
+func findScalarNode(node *yaml.Node, keyPath []string) (*yaml.Node, bool, error) {
 	if len(keyPath) == 0 {
 		if node.Kind == yaml.ScalarNode {
-			return true, node.Line - 1, node.Column - 1
+			return node, true, nil
 		}
-		return false, 0, 0
+		return nil, false, nil
 	}
 	switch node.Kind {
 	case yaml.DocumentNode:
@@ -134,9 +286,16 @@ func findScalarNode(node *yaml.Node, keyPath []string) (bool, int, int) {
 	case yaml.SequenceNode:
 		index, err := strconv.Atoi(keyPath[0])
 		if err != nil {
-			return false, 0, 0
+			return nil, false, nil
+		}
+		if index < 0 || index >= len(node.Content) {
+			return nil, false, fmt.Errorf(
+				"index %d is out of range for sequence of length %d",
+				index,
+				len(node.Content),
+			)
 		}
 		return findScalarNode(node.Content[index], keyPath[1:])
 	}
-	return false, 0, 0
+	return nil, false, nil
 }
@@ -1,6 +1,8 @@
 package yaml
 
 import (
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -8,6 +10,196 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
+func TestSetStringsInFile(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func(t *testing.T) string
+		changes    map[string]string
+		assertions func(*testing.T, string, error)
+	}{
+		{
+			name: "file does not exist",
+			setup: func(t *testing.T) string {
+				return filepath.Join(t.TempDir(), "values.yaml")
+			},
+			assertions: func(t *testing.T, _ string, err error) {
+				require.ErrorContains(t, err, "error reading file")
+			},
+		},
+		{
+			name: "error mutating bytes leaves file intact",
+			setup: func(t *testing.T) string {
+				file := filepath.Join(t.TempDir(), "values.yaml")
+				// Note: This YAML is invalid because one line is indented with a tab
+				require.NoError(t, os.WriteFile(file, []byte("image:\n\ttag: 1.18.0\n"), 0600))
+				return file
+			},
+			changes: map[string]string{
+				"image.tag": "1.19.0",
+			},
+			assertions: func(t *testing.T, file string, err error) {
+				require.ErrorContains(t, err, "error mutating bytes")
+				b, err := os.ReadFile(file)
+				require.NoError(t, err)
+				require.Equal(t, "image:\n\ttag: 1.18.0\n", string(b))
+				// No temporary files should have been left behind
+				entries, err := os.ReadDir(filepath.Dir(file))
+				require.NoError(t, err)
+				require.Len(t, entries, 1)
+			},
+		},
+		{
+			name: "success",
+			setup: func(t *testing.T) string {
+				file := filepath.Join(t.TempDir(), "values.yaml")
+				require.NoError(t, os.WriteFile(file, []byte("image:\n  tag: 1.18.0\n"), 0600))
+				// Set explicitly, as the mode passed to os.WriteFile is subject to umask
+				require.NoError(t, os.Chmod(file, 0644))
+				return file
+			},
+			changes: map[string]string{
+				"image.tag": "1.19.0",
+			},
+			assertions: func(t *testing.T, file string, err error) {
+				require.NoError(t, err)
+				b, err := os.ReadFile(file)
+				require.NoError(t, err)
+				require.Equal(t, "image:\n  tag: 1.19.0\n", string(b))
+				// The file's original permissions should be unchanged
+				fi, err := os.Stat(file)
+				require.NoError(t, err)
+				require.Equal(t, os.FileMode(0644), fi.Mode().Perm())
+			},
+		},
+		{
+			name: "symlinked file",
+			setup: func(t *testing.T) string {
+				dir := t.TempDir()
+				target := filepath.Join(dir, "common", "values.yaml")
+				require.NoError(t, os.Mkdir(filepath.Dir(target), 0755))
+				require.NoError(t, os.WriteFile(target, []byte("image:\n  tag: 1.18.0\n"), 0600))
+				file := filepath.Join(dir, "values.yaml")
+				require.NoError(t, os.Symlink(filepath.Join("common", "values.yaml"), file))
+				return file
+			},
+			changes: map[string]string{
+				"image.tag": "1.19.0",
+			},
+			assertions: func(t *testing.T, file string, err error) {
+				require.NoError(t, err)
+				// The symlink should still be a symlink to the same place
+				fi, err := os.Lstat(file)
+				require.NoError(t, err)
+				require.Equal(t, os.ModeSymlink, fi.Mode().Type())
+				dest, err := os.Readlink(file)
+				require.NoError(t, err)
+				require.Equal(t, filepath.Join("common", "values.yaml"), dest)
+				// And the file it points to should have been updated
+				b, err := os.ReadFile(filepath.Join(filepath.Dir(file), "common", "values.yaml"))
+				require.NoError(t, err)
+				require.Equal(t, "image:\n  tag: 1.19.0\n", string(b))
+				// No temporary files should have been left behind in either place
+				entries, err := os.ReadDir(filepath.Dir(file))
+				require.NoError(t, err)
+				require.Len(t, entries, 2)
+				entries, err = os.ReadDir(filepath.Join(filepath.Dir(file), "common"))
+				require.NoError(t, err)
+				require.Len(t, entries, 1)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			file := testCase.setup(t)
+			testCase.assertions(t, file, SetStringsInFile(file, testCase.changes))
+		})
+	}
+}
+
+func TestWriteFileAtomically(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func(t *testing.T) string
+		assertions func(*testing.T, string, error)
+	}{
+		{
+			name: "error replacing file",
+			setup: func(t *testing.T) string {
+				// Renaming a file over a non-empty directory will fail
+				file := filepath.Join(t.TempDir(), "values.yaml")
+				require.NoError(t, os.Mkdir(file, 0755))
+				require.NoError(
+					t,
+					os.WriteFile(filepath.Join(file, "foo.yaml"), []byte("foo: bar\n"), 0600),
+				)
+				return file
+			},
+			assertions: func(t *testing.T, file string, err error) {
+				require.ErrorContains(t, err, "error replacing file")
+				// The original should be intact
+				fi, err := os.Stat(file)
+				require.NoError(t, err)
+				require.True(t, fi.IsDir())
+				b, err := os.ReadFile(filepath.Join(file, "foo.yaml"))
+				require.NoError(t, err)
+				require.Equal(t, "foo: bar\n", string(b))
+				// No temporary files should have been left behind
+				entries, err := os.ReadDir(filepath.Dir(file))
+				require.NoError(t, err)
+				require.Len(t, entries, 1)
+			},
+		},
+		{
+			name: "dangling symlink",
+			setup: func(t *testing.T) string {
+				file := filepath.Join(t.TempDir(), "values.yaml")
+				require.NoError(t, os.Symlink("nonexistent.yaml", file))
+				return file
+			},
+			assertions: func(t *testing.T, file string, err error) {
+				require.ErrorContains(t, err, "error resolving file")
+				// Nothing should have been created
+				entries, err := os.ReadDir(filepath.Dir(file))
+				require.NoError(t, err)
+				require.Len(t, entries, 1)
+			},
+		},
+		{
+			name: "success",
+			setup: func(t *testing.T) string {
+				file := filepath.Join(t.TempDir(), "values.yaml")
+				require.NoError(t, os.WriteFile(file, []byte("foo: bar\n"), 0600))
+				// Set explicitly, as the mode passed to os.WriteFile is subject to umask
+				require.NoError(t, os.Chmod(file, 0640))
+				return file
+			},
+			assertions: func(t *testing.T, file string, err error) {
+				require.NoError(t, err)
+				b, err := os.ReadFile(file)
+				require.NoError(t, err)
+				require.Equal(t, "foo: baz\n", string(b))
+				fi, err := os.Stat(file)
+				require.NoError(t, err)
+				require.Equal(t, os.FileMode(0640), fi.Mode().Perm())
+				// No temporary files should have been left behind
+				entries, err := os.ReadDir(filepath.Dir(file))
+				require.NoError(t, err)
+				require.Len(t, entries, 1)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			file := testCase.setup(t)
+			testCase.assertions(
+				t,
+				file,
+				writeFileAtomically(file, []byte("foo: baz\n")),
+			)
+		})
+	}
+}
+
 func TestSetStringsInBytes(t *testing.T) {
 	testCases := []struct {
 		name       string
@@ -51,6 +243,276 @@ characters:
 				)
 			},
 		},
+		{
+			name: "bracketed indices in nested sequences",
+			inBytes: []byte(`
+containers:
+- name: app
+  image: nginx:1.18.0
+  env:
+  - name: FOO
+    value: foo
+  - name: BAR
+    value: bar
+`),
+			changes: map[string]string{
+				"containers[0].image":        "nginx:1.19.0",
+				"containers[0].env[1].value": "baz",
+			},
+			assertions: func(t *testing.T, bytes []byte, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					[]byte(`
+containers:
+- name: app
+  image: nginx:1.19.0
+  env:
+  - name: FOO
+    value: foo
+  - name: BAR
+    value: baz
+`),
+					bytes,
+				)
+			},
+		},
+		{
+			name: "negative index",
+			inBytes: []byte(`
+containers:
+- name: app
+  image: nginx:1.18.0
+`),
+			changes: map[string]string{
+				"containers[-1].image": "nginx:1.19.0",
+			},
+			assertions: func(t *testing.T, bytes []byte, err error) {
+				require.ErrorContains(t, err, `error resolving key "containers[-1].image"`)
+				require.ErrorContains(t, err, "index -1 is out of range")
+				require.Nil(t, bytes)
+			},
+		},
+		{
+			name: "brackets not enclosing an index are part of the key",
+			inBytes: []byte(`
+annotations:
+  foo[bar]: baz
+`),
+			changes: map[string]string{
+				"annotations.foo[bar]": "qux",
+			},
+			assertions: func(t *testing.T, bytes []byte, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					[]byte(`
+annotations:
+  foo[bar]: qux
+`),
+					bytes,
+				)
+			},
+		},
+		{
+			name: "index out of range",
+			inBytes: []byte(`
+containers:
+- name: app
+  image: nginx:1.18.0
+`),
+			changes: map[string]string{
+				"containers[1].image": "nginx:1.19.0",
+			},
+			assertions: func(t *testing.T, bytes []byte, err error) {
+				require.ErrorContains(t, err, `error resolving key "containers[1].image"`)
+				require.ErrorContains(t, err, "index 1 is out of range")
+				require.Nil(t, bytes)
+			},
+		},
+		{
+			name:    "CRLF line endings are preserved",
+			inBytes: []byte("image:\r\n  repository: nginx # comment\r\n  tag: 1.18.0 # pinned\r\n"),
+			changes: map[string]string{
+				"image.tag": "1.19.0",
+			},
+			assertions: func(t *testing.T, bytes []byte, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					"image:\r\n  repository: nginx # comment\r\n  tag: 1.19.0 # pinned\r\n",
+					string(bytes),
+				)
+			},
+		},
+		{
+			name: "anchors and aliases are preserved",
+			inBytes: []byte(`
+image:
+  tag: &tag 1.18.0 # pinned
+sidecar:
+  tag: *tag
+`),
+			changes: map[string]string{
+				"image.tag": "1.19.0",
+			},
+			assertions: func(t *testing.T, b []byte, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					[]byte(`
+image:
+  tag: &tag 1.19.0 # pinned
+sidecar:
+  tag: *tag
+`),
+					b,
+				)
+				// The alias should resolve to the new value
+				var out map[string]map[string]string
+				require.NoError(t, yaml.Unmarshal(b, &out))
+				require.Equal(t, "1.19.0", out["sidecar"]["tag"])
+			},
+		},
+		{
+			name:    "explicit tags and quoting are preserved",
+			inBytes: []byte("image:\n  repository: !!str \"nginx\" # upstream\n  tag: !!str '1.18.0'\n"),
+			changes: map[string]string{
+				"image.repository": `"docker.io/library/nginx"`,
+				"image.tag":        "'1.19.0'",
+			},
+			assertions: func(t *testing.T, bytes []byte, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					"image:\n  repository: !!str \"docker.io/library/nginx\" # upstream\n  tag: !!str '1.19.0'\n",
+					string(bytes),
+				)
+			},
+		},
+		{
+			name:    "flow mappings are preserved",
+			inBytes: []byte("image: {repository: nginx, tag: 1.18.0} # flow\n"),
+			changes: map[string]string{
+				"image.repository": "docker.io/library/nginx",
+				"image.tag":        "1.19.0",
+			},
+			assertions: func(t *testing.T, bytes []byte, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					"image: {repository: docker.io/library/nginx, tag: 1.19.0} # flow\n",
+					string(bytes),
+				)
+			},
+		},
+		{
+			name:    "block scalars are not supported",
+			inBytes: []byte("image:\n  tag: |\n    1.18.0\n"),
+			changes: map[string]string{
+				"image.tag": "1.19.0",
+			},
+			assertions: func(t *testing.T, bytes []byte, err error) {
+				require.ErrorContains(t, err, `error updating key "image.tag"`)
+				require.ErrorContains(t, err, "block scalars spanning multiple lines are not supported")
+				require.Nil(t, bytes)
+			},
+		},
+		{
+			name:    "mixed line endings are preserved",
+			inBytes: []byte("image:\r\n  tag: 1.18.0\r\nreplicas: 1\n"),
+			changes: map[string]string{
+				"image.tag": "1.19.0",
+			},
+			assertions: func(t *testing.T, bytes []byte, err error) {
+				require.NoError(t, err)
+				require.Equal(t, "image:\r\n  tag: 1.19.0\r\nreplicas: 1\n", string(bytes))
+			},
+		},
+		{
+			name:    "missing final line ending is preserved",
+			inBytes: []byte("image:\n  tag: 1.18.0"),
+			changes: map[string]string{
+				"image.tag": "1.19.0",
+			},
+			assertions: func(t *testing.T, bytes []byte, err error) {
+				require.NoError(t, err)
+				require.Equal(t, "image:\n  tag: 1.19.0", string(bytes))
+			},
+		},
+		{
+			name: "comment trailing an empty value is preserved",
+			inBytes: []byte(`
+image:
+  repository: nginx
+  tag: # set by ci
+`),
+			changes: map[string]string{
+				"image.tag": "1.19.0",
+			},
+			assertions: func(t *testing.T, bytes []byte, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					[]byte(`
+image:
+  repository: nginx
+  tag: 1.19.0 # set by ci
+`),
+					bytes,
+				)
+			},
+		},
+		{
+			name: "indentation is preserved",
+			inBytes: []byte(`
+image:
+    repository: nginx
+    tag: 1.18.0
+sidecars:
+    -   name: busybox
+        image: busybox:1.35
+`),
+			changes: map[string]string{
+				"image.tag":         "1.19.0",
+				"sidecars[0].image": "busybox:1.36",
+			},
+			assertions: func(t *testing.T, bytes []byte, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					[]byte(`
+image:
+    repository: nginx
+    tag: 1.19.0
+sidecars:
+    -   name: busybox
+        image: busybox:1.36
+`),
+					bytes,
+				)
+			},
+		},
+		{
+			name:    "only the changed line differs",
+			inBytes: []byte(largeValues),
+			changes: map[string]string{
+				"image.tag": "'1.19.0'",
+			},
+			assertions: func(t *testing.T, bytes []byte, err error) {
+				require.NoError(t, err)
+				inLines := strings.Split(largeValues, "\n")
+				outLines := strings.Split(string(bytes), "\n")
+				require.Len(t, outLines, len(inLines))
+				var diffs []string
+				for i := range inLines {
+					if inLines[i] != outLines[i] {
+						diffs = append(diffs, outLines[i])
+					}
+				}
+				require.Equal(t, []string{"  tag: '1.19.0' # pinned by Kargo"}, diffs)
+			},
+		},
 	}
 	for _, testCase := range testCases {
 		t.Run(testCase.name, func(t *testing.T) {
@@ -60,6 +522,76 @@ characters:
 	}
 }
 
+func TestNormalizeKey(t *testing.T) {
+	testCases := []struct {
+		key      string
+		expected string
+	}{
+		{
+			key:      "containers.0.image",
+			expected: "containers.0.image",
+		},
+		{
+			key:      "containers[0].image",
+			expected: "containers.0.image",
+		},
+		{
+			key:      "annotations.foo[bar]",
+			expected: "annotations.foo[bar]",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.key, func(t *testing.T) {
+			require.Equal(t, testCase.expected, NormalizeKey(testCase.key))
+		})
+	}
+}
+
+func TestSplitKey(t *testing.T) {
+	testCases := []struct {
+		key      string
+		expected []string
+	}{
+		{
+			key:      "image.tag",
+			expected: []string{"image", "tag"},
+		},
+		{
+			key:      "containers.0.image",
+			expected: []string{"containers", "0", "image"},
+		},
+		{
+			key:      "containers[0].image",
+			expected: []string{"containers", "0", "image"},
+		},
+		{
+			key:      "containers[0].env[2].value",
+			expected: []string{"containers", "0", "env", "2", "value"},
+		},
+		{
+			key:      "matrix[0][1]",
+			expected: []string{"matrix", "0", "1"},
+		},
+		{
+			key:      "containers[-1].image",
+			expected: []string{"containers", "-1", "image"},
+		},
+		{
+			key:      "annotations.foo[bar]",
+			expected: []string{"annotations", "foo[bar]"},
+		},
+		{
+			key:      "containers[0",
+			expected: []string{"containers[0"},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.key, func(t *testing.T) {
+			require.Equal(t, testCase.expected, splitKey(testCase.key))
+		})
+	}
+}
+
 func TestFindScalarNode(t *testing.T) {
 	yamlBytes := []byte(`
 characters:
@@ -69,15 +601,15 @@ characters:
 	testCases := []struct {
 		name       string
 		keyPath    string
-		assertions func(t *testing.T, found bool, line, col int)
+		assertions func(t *testing.T, node *yaml.Node, found bool, err error)
 	}{
 		{
 			name:    "node not found",
 			keyPath: "characters.imperials",
-			assertions: func(t *testing.T, found bool, line, col int) {
+			assertions: func(t *testing.T, node *yaml.Node, found bool, err error) {
+				require.NoError(t, err)
 				require.False(t, found)
-				require.Zero(t, line)
-				require.Zero(t, col)
+				require.Nil(t, node)
 			},
 		},
 		{
@@ -85,28 +617,38 @@ characters:
 			// Really, this is a special case of a key that doesn't address a node,
 			// because there is alpha input where numeric input would be expected.
 			keyPath: "characters.rebels.first.name",
-			assertions: func(t *testing.T, found bool, line, col int) {
+			assertions: func(t *testing.T, node *yaml.Node, found bool, err error) {
+				require.NoError(t, err)
+				require.False(t, found)
+				require.Nil(t, node)
+			},
+		},
+		{
+			name:    "index out of range",
+			keyPath: "characters.rebels.1.name",
+			assertions: func(t *testing.T, node *yaml.Node, found bool, err error) {
+				require.ErrorContains(t, err, "index 1 is out of range for sequence of length 1")
 				require.False(t, found)
-				require.Zero(t, line)
-				require.Zero(t, col)
+				require.Nil(t, node)
 			},
 		},
 		{
 			name:    "node found, but isn't a scalar node",
 			keyPath: "characters.rebels",
-			assertions: func(t *testing.T, found bool, line, col int) {
+			assertions: func(t *testing.T, node *yaml.Node, found bool, err error) {
+				require.NoError(t, err)
 				require.False(t, found)
-				require.Zero(t, line)
-				require.Zero(t, col)
+				require.Nil(t, node)
 			},
 		},
 		{
 			name:    "success",
 			keyPath: "characters.rebels.0.name",
-			assertions: func(t *testing.T, found bool, line, col int) {
+			assertions: func(t *testing.T, node *yaml.Node, found bool, err error) {
+				require.NoError(t, err)
 				require.True(t, found)
-				require.Equal(t, 3, line)
-				require.Equal(t, 10, col)
+				require.Equal(t, 4, node.Line)
+				require.Equal(t, 11, node.Column)
 			},
 		},
 	}
@@ -115,8 +657,67 @@ characters:
 	require.NoError(t, err)
 	for _, testCase := range testCases {
 		t.Run(testCase.name, func(t *testing.T) {
-			found, line, col := findScalarNode(doc, strings.Split(testCase.keyPath, "."))
-			testCase.assertions(t, found, line, col)
+			node, found, err := findScalarNode(doc, strings.Split(testCase.keyPath, "."))
+			testCase.assertions(t, node, found, err)
 		})
 	}
 }
+
+// largeValues is a representative Helm values file containing comments, blank
+// lines, anchors, and aliases, none of which should be disturbed by an update.
+const largeValues = `# Default values for my-app.
+
+replicaCount: 1
+
+defaults: &defaults
+  pullPolicy: IfNotPresent
+  # Overrides the image tag whose default is the chart appVersion.
+  registry: docker.io
+
+image:
+  <<: *defaults
+  repository: nginx
+  tag: '1.18.0' # pinned by Kargo
+
+imagePullSecrets: []
+nameOverride: ""
+fullnameOverride: ""
+
+serviceAccount:
+  create: true
+  annotations: {}
+  name: ""
+
+podAnnotations: {}
+
+podSecurityContext: {}
+
+securityContext: {}
+
+service:
+  type: ClusterIP
+  port: 80
+
+ingress:
+  enabled: false
+  className: ""
+  annotations: {}
+  hosts:
+    - host: chart-example.local
+      paths:
+        - path: /
+          pathType: ImplementationSpecific
+  tls: []
+
+resources: {}
+
+sidecar:
+  <<: *defaults
+  repository: busybox
+
+nodeSelector: {}
+
+tolerations: []
+
+affinity: {}
+`